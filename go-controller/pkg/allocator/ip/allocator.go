@@ -0,0 +1,300 @@
+// Package ip implements a simple IP address allocator over an arbitrary
+// IPv4 or IPv6 subnet. It is used by the OVN logical switch manager to hand
+// out addresses from a node's host subnet(s).
+package ip
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// Allocator hands out and reclaims individual IP addresses carved out of a
+// single subnet. Callers are expected to serialize access; implementations
+// are not required to be safe for concurrent use.
+type Allocator interface {
+	// Allocate reserves ip. It returns an error if ip falls outside the
+	// allocator's subnet or allocatable range, or is already allocated or
+	// excluded.
+	Allocate(ip net.IP) error
+	// AllocateNext reserves and returns the next free address in the
+	// subnet. It returns an error if the allocator is exhausted.
+	AllocateNext() (net.IP, error)
+	// Release frees ip so it can be handed out again. Releasing an
+	// address that was never allocated is a no-op.
+	Release(ip net.IP)
+	// Has returns true if ip is currently allocated.
+	Has(ip net.IP) bool
+	// Exclude permanently removes ip from the allocatable range, without
+	// marking it as allocated. It is used to carve out infrastructure
+	// addresses (gateway/management ports) up front, and survives a later
+	// ApplyPolicy call.
+	Exclude(ip net.IP) error
+	// ApplyPolicy (re)applies policy's range and exclusion constraints.
+	// A nil policy resets the allocator to the whole subnet. ApplyPolicy
+	// never touches addresses already allocated, so it can be called
+	// again on a live allocator to reconcile a changed policy.
+	ApplyPolicy(policy *Policy) error
+}
+
+// Policy constrains which addresses of a subnet an allocator may hand out.
+// It mirrors the IPPool model used by CNI IPAM plugins such as whereabouts,
+// letting operators carve a subnet up for static VIPs, gateway pools, or
+// reserved DHCP ranges.
+type Policy struct {
+	// RangeStart and RangeEnd optionally restrict allocation to the
+	// inclusive window [RangeStart, RangeEnd] within the subnet. A nil
+	// bound defaults to the start/end of the subnet.
+	RangeStart net.IP
+	RangeEnd   net.IP
+	// Excludes lists additional CIDRs (use a /32 or /128 for a single IP)
+	// that must never be handed out, even if they fall inside the range.
+	Excludes []*net.IPNet
+}
+
+// offsetRange is an inclusive range of offsets from a subnet's base address.
+type offsetRange struct {
+	start, end *big.Int
+}
+
+func (r offsetRange) contains(offset *big.Int) bool {
+	return offset.Cmp(r.start) >= 0 && offset.Cmp(r.end) <= 0
+}
+
+// allocator is the default, map-backed Allocator implementation. It tracks
+// allocated offsets from the subnet's base address rather than
+// materializing a full bitmap, so it works equally well for small IPv4
+// subnets and sparsely-used IPv6 /64s.
+type allocator struct {
+	subnet    *net.IPNet
+	isIPv4    bool
+	base      *big.Int
+	size      *big.Int
+	allocated map[string]struct{}
+
+	// manualExcluded comes from Exclude() calls (e.g. infrastructure port
+	// reservations) and is never touched by ApplyPolicy.
+	manualExcluded []offsetRange
+	// policyExcluded is recomputed from scratch on every ApplyPolicy call:
+	// the network/broadcast address plus policy.Excludes.
+	policyExcluded []offsetRange
+
+	rangeStart *big.Int
+	rangeEnd   *big.Int
+	next       *big.Int
+}
+
+// NewAllocator returns an Allocator over the whole of subnet.
+func NewAllocator(subnet *net.IPNet) Allocator {
+	a := newBareAllocator(subnet)
+	// a nil policy can't fail to apply
+	_ = a.ApplyPolicy(nil)
+	return a
+}
+
+// NewAllocatorWithPolicy returns an Allocator over subnet, constrained by
+// policy. A nil policy is equivalent to NewAllocator.
+func NewAllocatorWithPolicy(subnet *net.IPNet, policy *Policy) (Allocator, error) {
+	a := newBareAllocator(subnet)
+	if err := a.ApplyPolicy(policy); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func newBareAllocator(subnet *net.IPNet) *allocator {
+	ones, bits := subnet.Mask.Size()
+	return &allocator{
+		subnet:    subnet,
+		isIPv4:    subnet.IP.To4() != nil,
+		base:      ipToInt(subnet.IP),
+		size:      new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)),
+		allocated: map[string]struct{}{},
+	}
+}
+
+func (a *allocator) ApplyPolicy(policy *Policy) error {
+	rangeStart := big.NewInt(0)
+	rangeEnd := new(big.Int).Sub(a.size, big.NewInt(1))
+	var excludes []*net.IPNet
+
+	if policy != nil {
+		if policy.RangeStart != nil {
+			off, err := a.offsetOf(policy.RangeStart)
+			if err != nil {
+				return fmt.Errorf("invalid range start %s: %v", policy.RangeStart, err)
+			}
+			rangeStart = off
+		}
+		if policy.RangeEnd != nil {
+			off, err := a.offsetOf(policy.RangeEnd)
+			if err != nil {
+				return fmt.Errorf("invalid range end %s: %v", policy.RangeEnd, err)
+			}
+			rangeEnd = off
+		}
+		if rangeStart.Cmp(rangeEnd) > 0 {
+			return fmt.Errorf("range start %s is after range end %s", policy.RangeStart, policy.RangeEnd)
+		}
+		excludes = policy.Excludes
+	}
+
+	policyExcluded := []offsetRange{{start: big.NewInt(0), end: big.NewInt(0)}} // network address
+	if a.isIPv4 {
+		broadcast := new(big.Int).Sub(a.size, big.NewInt(1))
+		policyExcluded = append(policyExcluded, offsetRange{start: broadcast, end: broadcast})
+	}
+	for _, cidr := range excludes {
+		startOff, err := a.offsetOf(cidr.IP)
+		if err != nil {
+			return fmt.Errorf("invalid exclude %s: %v", cidr, err)
+		}
+		ones, bits := cidr.Mask.Size()
+		count := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+		endOff := new(big.Int).Add(startOff, new(big.Int).Sub(count, big.NewInt(1)))
+		policyExcluded = append(policyExcluded, offsetRange{start: startOff, end: endOff})
+	}
+
+	a.rangeStart = rangeStart
+	a.rangeEnd = rangeEnd
+	a.policyExcluded = policyExcluded
+	if a.next == nil || !a.inRange(a.next) {
+		a.next = new(big.Int).Set(rangeStart)
+	}
+	return nil
+}
+
+func (a *allocator) Allocate(ip net.IP) error {
+	offset, err := a.offsetOf(ip)
+	if err != nil {
+		return err
+	}
+	if !a.inRange(offset) {
+		return fmt.Errorf("IP %s is outside the allocatable range of subnet %s", ip, a.subnet)
+	}
+	if a.isExcluded(offset) {
+		return fmt.Errorf("IP %s is excluded from allocation in subnet %s", ip, a.subnet)
+	}
+	key := offset.String()
+	if _, ok := a.allocated[key]; ok {
+		return fmt.Errorf("IP %s is already allocated in subnet %s", ip, a.subnet)
+	}
+	a.allocated[key] = struct{}{}
+	return nil
+}
+
+func (a *allocator) AllocateNext() (net.IP, error) {
+	start := new(big.Int).Set(a.next)
+	if !a.inRange(start) {
+		start = new(big.Int).Set(a.rangeStart)
+	}
+	offset := new(big.Int).Set(start)
+	for first := true; first || offset.Cmp(start) != 0; first = false {
+		if !a.isExcluded(offset) {
+			key := offset.String()
+			if _, ok := a.allocated[key]; !ok {
+				a.allocated[key] = struct{}{}
+				a.next = a.wrappingNext(offset)
+				return a.intToIP(offset), nil
+			}
+		}
+		offset = a.wrappingNext(offset)
+	}
+	return nil, fmt.Errorf("no addresses left in subnet %s", a.subnet)
+}
+
+// wrappingNext returns the offset after offset, wrapping back to rangeStart
+// once rangeEnd has been passed.
+func (a *allocator) wrappingNext(offset *big.Int) *big.Int {
+	next := new(big.Int).Add(offset, big.NewInt(1))
+	if next.Cmp(a.rangeEnd) > 0 {
+		next = new(big.Int).Set(a.rangeStart)
+	}
+	return next
+}
+
+func (a *allocator) Release(ip net.IP) {
+	offset, err := a.offsetOf(ip)
+	if err != nil {
+		return
+	}
+	delete(a.allocated, offset.String())
+}
+
+func (a *allocator) Has(ip net.IP) bool {
+	offset, err := a.offsetOf(ip)
+	if err != nil {
+		return false
+	}
+	_, ok := a.allocated[offset.String()]
+	return ok
+}
+
+func (a *allocator) Exclude(ip net.IP) error {
+	offset, err := a.offsetOf(ip)
+	if err != nil {
+		return err
+	}
+	if _, ok := a.allocated[offset.String()]; ok {
+		return fmt.Errorf("IP %s is already allocated in subnet %s and cannot be excluded", ip, a.subnet)
+	}
+	a.manualExcluded = append(a.manualExcluded, offsetRange{start: offset, end: offset})
+	return nil
+}
+
+func (a *allocator) inRange(offset *big.Int) bool {
+	return offset.Cmp(a.rangeStart) >= 0 && offset.Cmp(a.rangeEnd) <= 0
+}
+
+func (a *allocator) isExcluded(offset *big.Int) bool {
+	for _, r := range a.manualExcluded {
+		if r.contains(offset) {
+			return true
+		}
+	}
+	for _, r := range a.policyExcluded {
+		if r.contains(offset) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *allocator) offsetOf(ip net.IP) (*big.Int, error) {
+	if !a.subnet.Contains(ip) {
+		return nil, fmt.Errorf("IP %s is not contained in subnet %s", ip, a.subnet)
+	}
+	offset := new(big.Int).Sub(ipToInt(ip), a.base)
+	return offset, nil
+}
+
+func (a *allocator) intToIP(offset *big.Int) net.IP {
+	sum := new(big.Int).Add(a.base, offset)
+	return intToIP(sum, len(a.subnet.IP))
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if v4 := ip.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(v *big.Int, size int) net.IP {
+	b := v.Bytes()
+	ip := make(net.IP, size)
+	copy(ip[size-len(b):], b)
+	return ip
+}
+
+// AddOffset returns the address that is offset addresses past base, e.g.
+// AddOffset(10.1.1.0, 3) returns 10.1.1.3.
+func AddOffset(base net.IP, offset uint64) net.IP {
+	size := len(base)
+	if v4 := base.To4(); v4 != nil {
+		size = len(v4)
+		base = v4
+	}
+	sum := new(big.Int).Add(ipToInt(base), new(big.Int).SetUint64(offset))
+	return intToIP(sum, size)
+}
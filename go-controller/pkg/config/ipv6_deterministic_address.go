@@ -0,0 +1,82 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+// IPv6DeterministicAddress holds the opt-in settings controlling whether a
+// pod's IPv6 address is derived deterministically from its namespace/name
+// instead of the next free bitmap slot. See
+// ovn.allocateDeterministicIPv6.
+var IPv6DeterministicAddress = IPv6DeterministicAddressConfig{}
+
+// IPv6DeterministicAddressConfig is the configuration for deterministic
+// IPv6 pod address assignment.
+type IPv6DeterministicAddressConfig struct {
+	// Enabled turns on deterministic IPv6 addressing.
+	Enabled bool `gcfg:"enabled"`
+	// ClusterSecret is the HMAC key used to derive a pod's address from
+	// its namespace/name. If empty and SecretFile is set, it is loaded
+	// by LoadIPv6DeterministicAddressSecret instead of being set here
+	// directly.
+	ClusterSecret string `gcfg:"cluster-secret"`
+	// SecretFile is the on-disk path ClusterSecret is persisted to when
+	// it isn't supplied directly, so a restart reuses the same secret
+	// instead of generating a new one and reshuffling every pod's
+	// deterministic address.
+	SecretFile string `gcfg:"cluster-secret-file"`
+}
+
+// IPv6DeterministicAddressFlags are the CLI flags wired to
+// IPv6DeterministicAddress.
+var IPv6DeterministicAddressFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:        "enable-ipv6-deterministic-address",
+		Usage:       "Enables deriving a pod's IPv6 address deterministically from its namespace/name, so a recreated pod keeps the same address.",
+		Destination: &IPv6DeterministicAddress.Enabled,
+	},
+	&cli.StringFlag{
+		Name:        "ipv6-deterministic-address-secret-file",
+		Usage:       "Path to the HMAC secret used for deterministic IPv6 addressing. Generated and persisted here on first use if the file doesn't already exist, so restarts keep the same address mapping.",
+		Destination: &IPv6DeterministicAddress.SecretFile,
+	},
+}
+
+// LoadIPv6DeterministicAddressSecret populates
+// IPv6DeterministicAddress.ClusterSecret from SecretFile, generating and
+// persisting a new random secret if the file doesn't exist yet. It is a
+// no-op if deterministic addressing isn't enabled, if ClusterSecret was
+// already set directly (e.g. from a config file), or if no secret file was
+// configured, in which case ClusterSecret must already have been set
+// directly.
+func LoadIPv6DeterministicAddressSecret() error {
+	if !IPv6DeterministicAddress.Enabled || IPv6DeterministicAddress.ClusterSecret != "" || IPv6DeterministicAddress.SecretFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(IPv6DeterministicAddress.SecretFile)
+	if err == nil {
+		IPv6DeterministicAddress.ClusterSecret = string(data)
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read IPv6 deterministic address secret file %q: %v", IPv6DeterministicAddress.SecretFile, err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate IPv6 deterministic address secret: %v", err)
+	}
+	encoded := hex.EncodeToString(secret)
+	if err := ioutil.WriteFile(IPv6DeterministicAddress.SecretFile, []byte(encoded), 0600); err != nil {
+		return fmt.Errorf("failed to persist IPv6 deterministic address secret to %q: %v", IPv6DeterministicAddress.SecretFile, err)
+	}
+	IPv6DeterministicAddress.ClusterSecret = encoded
+	return nil
+}
@@ -0,0 +1,55 @@
+package ovn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	ipallocator "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/allocator/ip"
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/config"
+)
+
+// maxDeterministicIPv6Probe bounds the linear probe performed when a
+// deterministic IPv6 address is already taken. A collision is exceedingly
+// unlikely for a /64 host subnet; this just keeps a pathological case from
+// spinning forever.
+const maxDeterministicIPv6Probe = 1024
+
+// isIPv6Subnet returns true if subnet is an IPv6 subnet.
+func isIPv6Subnet(subnet *net.IPNet) bool {
+	return subnet.IP.To4() == nil
+}
+
+// allocateDeterministicIPv6 reserves, in alloc, the address within subnet
+// that HMAC-SHA256(config.IPv6DeterministicAddress.ClusterSecret, podKey)
+// deterministically maps to: the low (host bits) of the HMAC masked onto
+// subnet's host portion. podKey is expected to be a stable pod identifier
+// (namespace/name) so the same pod gets the same address across recreation,
+// unlike podUID which changes every time. If the derived address is already
+// taken (by a collision, or because it happens to be an infrastructure
+// address), it falls back to a linear probe within the subnet.
+func allocateDeterministicIPv6(alloc ipallocator.Allocator, subnet *net.IPNet, podKey string) (net.IP, error) {
+	ones, bits := subnet.Mask.Size()
+	hostBits := uint(bits - ones)
+	if hostBits > 64 {
+		return nil, fmt.Errorf("deterministic IPv6 addressing does not support subnet %s: host portion exceeds 64 bits", subnet)
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.IPv6DeterministicAddress.ClusterSecret))
+	mac.Write([]byte(podKey))
+	sum := mac.Sum(nil)
+
+	mask := uint64(1)<<hostBits - 1
+	offset := binary.BigEndian.Uint64(sum[len(sum)-8:]) & mask
+
+	for i := uint64(0); i < maxDeterministicIPv6Probe; i++ {
+		candidate := ipallocator.AddOffset(subnet.IP, (offset+i)&mask)
+		if err := alloc.Allocate(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to find a free deterministic IPv6 address in subnet %s for pod %q after %d probes",
+		subnet, podKey, maxDeterministicIPv6Probe)
+}
@@ -0,0 +1,49 @@
+package ovn
+
+import (
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/config"
+	ovntest "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OVN Logical Switch Manager deterministic IPv6 addressing", func() {
+	var lsManager *logicalSwitchManager
+	nodeName := "testNode1"
+	podKey := "default/webserver"
+
+	BeforeEach(func() {
+		config.PrepareTestConfig()
+		config.IPv6DeterministicAddress.Enabled = true
+		config.IPv6DeterministicAddress.ClusterSecret = "test-cluster-secret"
+		lsManager = newLogicalSwitchManager()
+		Expect(lsManager.AddNode(nodeName, ovntest.MustParseIPNets("10.1.1.0/24", "2000::/64"))).To(Succeed())
+	})
+
+	It("assigns the same IPv6 address to a recreated pod, leaving IPv4 allocation untouched", func() {
+		first, err := lsManager.AllocateNextIPsForPod(nodeName, "pod-uid-1/eth0", podKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lsManager.ReleaseIPsFor("pod-uid-1/eth0")).To(Succeed())
+
+		// simulate the pod being recreated: same namespace/name, new UID
+		second, err := lsManager.AllocateNextIPsForPod(nodeName, "pod-uid-2/eth0", podKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(second[1].IP.String()).To(Equal(first[1].IP.String()))
+		// the freed IPv4 address isn't revisited until the bitmap wraps
+		// back around, so the recreated pod simply gets the next one
+		Expect(second[0].IP.String()).To(Equal("10.1.1.4"))
+	})
+
+	It("falls back to a linear probe when the derived IPv6 address is already taken", func() {
+		first, err := lsManager.AllocateNextIPsForPod(nodeName, "pod-uid-1/eth0", podKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		// force a collision: a second owner with the same pod key derives
+		// the address that's already held by the first pod
+		second, err := lsManager.AllocateNextIPsForPod(nodeName, "pod-uid-2/eth0", podKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second[1].IP.String()).NotTo(Equal(first[1].IP.String()))
+	})
+})
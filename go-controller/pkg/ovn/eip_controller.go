@@ -0,0 +1,51 @@
+package ovn
+
+import (
+	"fmt"
+	"net"
+)
+
+// EgressIP is the minimal shape of the EgressIP-like CRD spec this shim
+// reconciles against an EIPManager. The full CRD type, along with its
+// generated clientset and informer, is out of scope here; EIPController
+// only needs the fields below to drive allocation.
+type EgressIP struct {
+	// Owner identifies the pod this elastic IP is reserved for, e.g. its
+	// UID.
+	Owner string
+	// NodeName is the node the owning pod is currently scheduled on.
+	NodeName string
+	// LSPName is the logical switch port of the owning pod.
+	LSPName string
+}
+
+// EIPController reconciles EgressIP objects against an EIPManager. It is a
+// thin shim: nothing here wires it to an informer or workqueue, so callers
+// invoke Reconcile and ReconcileDelete themselves.
+type EIPController struct {
+	manager *EIPManager
+}
+
+// NewEIPController returns an EIPController backed by manager.
+func NewEIPController(manager *EIPManager) *EIPController {
+	return &EIPController{manager: manager}
+}
+
+// Reconcile ensures eip has an elastic IP allocated and associated with its
+// pod's logical switch port, returning the address.
+func (c *EIPController) Reconcile(eip *EgressIP) (net.IP, error) {
+	ip, err := c.manager.AllocateEIP(eip.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate elastic IP for %q: %v", eip.Owner, err)
+	}
+	if err := c.manager.AssociateEIP(ip, eip.LSPName, eip.NodeName); err != nil {
+		return nil, fmt.Errorf("failed to associate elastic IP %s for %q: %v", ip, eip.Owner, err)
+	}
+	return ip, nil
+}
+
+// ReconcileDelete releases the elastic IP reserved for owner, e.g. when its
+// EgressIP object or owning pod is deleted.
+func (c *EIPController) ReconcileDelete(owner string) error {
+	return c.manager.ReleaseEIP(owner)
+}
@@ -0,0 +1,204 @@
+package ovn
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	ipallocator "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/allocator/ip"
+
+	"k8s.io/klog"
+)
+
+// eipStoreNode is the pseudo switch name EIPManager uses to persist its
+// bindings through the same IPAMStore logicalSwitchManager uses, since the
+// store is keyed by switch/node name and the elastic IP pool isn't
+// node-scoped.
+const eipStoreNode = "__eip_pool__"
+
+// EIPNBClient programs the OVN northbound NAT entries backing a bound
+// elastic IP. EIPManager only depends on this interface; no implementation
+// of it backed by a real northbound database is included here.
+type EIPNBClient interface {
+	// EnsureEIPNAT creates (or updates) the 1:1 SNAT/DNAT pair on
+	// gatewayRouter mapping externalIP to the logical switch port lspName.
+	EnsureEIPNAT(gatewayRouter, lspName string, externalIP net.IP) error
+	// DeleteEIPNAT removes whatever EnsureEIPNAT created for externalIP on
+	// gatewayRouter.
+	DeleteEIPNAT(gatewayRouter string, externalIP net.IP) error
+}
+
+// eipBinding is one elastic IP's current state.
+type eipBinding struct {
+	ip            net.IP
+	owner         string
+	lspName       string // empty until AssociateEIP is called
+	gatewayRouter string
+}
+
+// EIPManager owns a pool of externally routable IPs drawn from one or more
+// configured CIDRs and binds them to pod logical switch ports on demand,
+// programming the 1:1 NAT OVN needs to forward traffic to/from them. It
+// reuses the same bitmap allocator machinery as logicalSwitchManager.
+type EIPManager struct {
+	sync.Mutex
+	cidrs      []*net.IPNet
+	allocators []ipallocator.Allocator
+	store      IPAMStore
+	nb         EIPNBClient
+
+	// bindings is keyed by owner (e.g. a pod UID, or a namespace for a
+	// namespace-wide elastic IP), mirroring logicalSwitchManager's owner
+	// tracking so repeated requests for the same owner are idempotent.
+	bindings map[string]*eipBinding
+}
+
+// NewEIPManager returns an EIPManager drawing elastic IPs from cidrs,
+// rehydrating any bindings already recorded in store.
+func NewEIPManager(cidrs []*net.IPNet, store IPAMStore, nb EIPNBClient) *EIPManager {
+	m := &EIPManager{
+		cidrs:    cidrs,
+		store:    store,
+		nb:       nb,
+		bindings: map[string]*eipBinding{},
+	}
+	for _, cidr := range cidrs {
+		m.allocators = append(m.allocators, ipallocator.NewAllocator(cidr))
+	}
+	m.rehydrate()
+	return m
+}
+
+func (m *EIPManager) rehydrate() {
+	nodes, err := m.store.Nodes()
+	if err != nil {
+		klog.Errorf("Failed to rehydrate EIP store, starting with an empty pool: %v", err)
+		return
+	}
+	stored, ok := nodes[eipStoreNode]
+	if !ok {
+		if err := m.store.AddNode(eipStoreNode, m.cidrs, nil); err != nil {
+			klog.Errorf("Failed to initialize EIP store: %v", err)
+		}
+		return
+	}
+	for _, owner := range stored.Owners {
+		if len(owner.IPs) == 0 {
+			continue
+		}
+		ip := owner.IPs[0].IP
+		for _, alloc := range m.allocators {
+			if alloc.Allocate(ip) == nil {
+				break
+			}
+		}
+		m.bindings[owner.OwnerKey] = &eipBinding{ip: ip, owner: owner.OwnerKey}
+	}
+	klog.Infof("Rehydrated %d elastic IP bindings from on-disk store", len(stored.Owners))
+}
+
+// AllocateEIP reserves and returns an elastic IP for owner, drawing from
+// whichever configured pool still has room. A second call for an owner that
+// already holds an elastic IP returns the same address.
+func (m *EIPManager) AllocateEIP(owner string) (net.IP, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if b, ok := m.bindings[owner]; ok {
+		return b.ip, nil
+	}
+
+	var ip net.IP
+	var err error
+	for _, alloc := range m.allocators {
+		ip, err = alloc.AllocateNext()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no elastic IPs left in any configured pool for owner %q", owner)
+	}
+
+	if err := m.store.ReserveOwner(eipStoreNode, owner, []*net.IPNet{{IP: ip}}); err != nil {
+		m.releaseFromAllocators(ip)
+		return nil, fmt.Errorf("failed to persist elastic IP allocation for %q: %v", owner, err)
+	}
+	m.bindings[owner] = &eipBinding{ip: ip, owner: owner}
+	return ip, nil
+}
+
+// AssociateEIP binds ip (previously handed out by AllocateEIP) to lspName,
+// the logical switch port that should receive its 1:1 NAT, and programs the
+// OVN NB NAT entries on nodeName's gateway router. Calling it again with a
+// different lspName/nodeName re-associates the elastic IP, floating it from
+// one pod to another.
+func (m *EIPManager) AssociateEIP(ip net.IP, lspName, nodeName string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	binding := m.bindingForIP(ip)
+	if binding == nil {
+		return fmt.Errorf("elastic IP %s has not been allocated", ip)
+	}
+
+	gatewayRouter := gatewayRouterName(nodeName)
+	if binding.lspName == lspName && binding.gatewayRouter == gatewayRouter {
+		return nil
+	}
+
+	if binding.lspName != "" {
+		if err := m.nb.DeleteEIPNAT(binding.gatewayRouter, ip); err != nil {
+			return fmt.Errorf("failed to remove NAT for elastic IP %s from %q: %v", ip, binding.gatewayRouter, err)
+		}
+	}
+	if err := m.nb.EnsureEIPNAT(gatewayRouter, lspName, ip); err != nil {
+		return fmt.Errorf("failed to program NAT for elastic IP %s on %q: %v", ip, gatewayRouter, err)
+	}
+	binding.lspName = lspName
+	binding.gatewayRouter = gatewayRouter
+	return nil
+}
+
+// ReleaseEIP tears down the NAT (if any) and frees the elastic IP held by
+// owner, e.g. on pod deletion. Releasing an owner with no elastic IP is a
+// no-op.
+func (m *EIPManager) ReleaseEIP(owner string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	binding, ok := m.bindings[owner]
+	if !ok {
+		return nil
+	}
+	if binding.lspName != "" {
+		if err := m.nb.DeleteEIPNAT(binding.gatewayRouter, binding.ip); err != nil {
+			return fmt.Errorf("failed to remove NAT for elastic IP %s from %q: %v", binding.ip, binding.gatewayRouter, err)
+		}
+	}
+	if err := m.store.ReleaseOwner(eipStoreNode, owner, []*net.IPNet{{IP: binding.ip}}); err != nil {
+		return fmt.Errorf("failed to persist elastic IP release for %q: %v", owner, err)
+	}
+	m.releaseFromAllocators(binding.ip)
+	delete(m.bindings, owner)
+	return nil
+}
+
+func (m *EIPManager) bindingForIP(ip net.IP) *eipBinding {
+	for _, b := range m.bindings {
+		if b.ip.Equal(ip) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *EIPManager) releaseFromAllocators(ip net.IP) {
+	for _, alloc := range m.allocators {
+		alloc.Release(ip)
+	}
+}
+
+func gatewayRouterName(nodeName string) string {
+	return "GR_" + nodeName
+}
@@ -0,0 +1,96 @@
+package ovn
+
+import (
+	"net"
+
+	ovntest "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeEIPNBClient records the NAT calls EIPManager makes instead of talking
+// to a real OVN northbound database.
+type fakeEIPNBClient struct {
+	ensured []string
+	deleted []string
+}
+
+func (f *fakeEIPNBClient) EnsureEIPNAT(gatewayRouter, lspName string, externalIP net.IP) error {
+	f.ensured = append(f.ensured, gatewayRouter+"/"+lspName+"/"+externalIP.String())
+	return nil
+}
+
+func (f *fakeEIPNBClient) DeleteEIPNAT(gatewayRouter string, externalIP net.IP) error {
+	f.deleted = append(f.deleted, gatewayRouter+"/"+externalIP.String())
+	return nil
+}
+
+var _ = Describe("OVN Elastic IP manager", func() {
+	var (
+		nb      *fakeEIPNBClient
+		manager *EIPManager
+	)
+
+	BeforeEach(func() {
+		nb = &fakeEIPNBClient{}
+		cidrs := ovntest.MustParseIPNets("172.16.0.0/30")
+		manager = NewEIPManager(cidrs, newNoopIPAMStore(), nb)
+	})
+
+	It("allocates from the pool and fails once it is exhausted", func() {
+		// 172.16.0.0/30 has only two allocatable addresses: .1 and .2 are
+		// infra-free here (no reservation logic in EIPManager), but .0 is
+		// the network address and .3 is the broadcast address
+		first, err := manager.AllocateEIP("pod-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.String()).To(Equal("172.16.0.1"))
+
+		second, err := manager.AllocateEIP("pod-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.String()).To(Equal("172.16.0.2"))
+
+		_, err = manager.AllocateEIP("pod-3")
+		Expect(err).To(HaveOccurred())
+
+		// repeating an existing owner is idempotent, not an exhaustion error
+		again, err := manager.AllocateEIP("pod-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again.String()).To(Equal("172.16.0.1"))
+	})
+
+	It("re-associates an elastic IP from one pod to another, reprogramming NAT", func() {
+		ip, err := manager.AllocateEIP("pod-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(manager.AssociateEIP(ip, "pod-1-lsp", "node1")).To(Succeed())
+		Expect(nb.ensured).To(ContainElement("GR_node1/pod-1-lsp/" + ip.String()))
+
+		Expect(manager.AssociateEIP(ip, "pod-2-lsp", "node1")).To(Succeed())
+		Expect(nb.deleted).To(ContainElement("GR_node1/" + ip.String()))
+		Expect(nb.ensured).To(ContainElement("GR_node1/pod-2-lsp/" + ip.String()))
+	})
+
+	It("releases the elastic IP and its NAT on pod deletion, freeing it for reuse", func() {
+		ip, err := manager.AllocateEIP("pod-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.AssociateEIP(ip, "pod-1-lsp", "node1")).To(Succeed())
+
+		Expect(manager.ReleaseEIP("pod-1")).To(Succeed())
+		Expect(nb.deleted).To(ContainElement("GR_node1/" + ip.String()))
+
+		// releasing an owner with nothing allocated is a no-op
+		Expect(manager.ReleaseEIP("pod-1")).To(Succeed())
+
+		// the pool's only other address is handed out next; the freed one
+		// isn't revisited until the bitmap wraps back around
+		reallocated, err := manager.AllocateEIP("pod-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reallocated.String()).To(Equal("172.16.0.2"))
+
+		// but a third request does wrap around and reclaim the freed IP
+		thirdReallocated, err := manager.AllocateEIP("pod-3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(thirdReallocated.String()).To(Equal(ip.String()))
+	})
+})
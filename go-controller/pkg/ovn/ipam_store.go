@@ -0,0 +1,285 @@
+package ovn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	ipallocator "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/allocator/ip"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// assignmentsBucket is the name of the per-node bucket mapping an owner key
+// (pod UID, LSP name, ...) to the list of IPs allocated to it.
+const assignmentsBucket = "assignments"
+
+// subnetOrderKey is the node-bucket key holding the JSON-encoded, ordered
+// list of a node's subnets (and their allocation policy, if any), as
+// originally passed to AddNode. Nodes() reads the list back from here
+// rather than deriving it by walking the node's per-subnet buckets with a
+// Cursor, which iterates in lexicographic byte order and would silently
+// reorder subnets relative to the caller's original []*net.IPNet.
+const subnetOrderKey = "subnets"
+
+// ownerAllocation is one entry of a node's assignments bucket.
+type ownerAllocation struct {
+	OwnerKey string
+	IPs      []*net.IPNet
+}
+
+// subnetEntry is one element of the subnetOrderKey list: a subnet and the
+// allocation Policy it was registered with, so a restart can reapply the
+// same range/exclusion constraints instead of handing out whatever the
+// policy had carved out.
+type subnetEntry struct {
+	Subnet *net.IPNet
+	Policy *ipallocator.Policy
+}
+
+// storedNode is everything the IPAM store knows about a single node's
+// logical switch.
+type storedNode struct {
+	Subnets  []*net.IPNet
+	Policies []*ipallocator.Policy
+	Owners   []ownerAllocation
+}
+
+// IPAMStore persists logicalSwitchManager's allocation state so that
+// ovnkube-master does not have to reconstruct IPAM by scanning LSPs and Pods
+// on every restart.
+type IPAMStore interface {
+	// AddNode (re)creates the on-disk buckets for nodeName's subnets,
+	// discarding any allocations previously recorded for that node.
+	// policies[i], if non-nil, is the allocation Policy subnets[i] was
+	// registered with, and is reapplied by Nodes()/rehydrate on restart.
+	// A nil policies slice means no subnet has a policy.
+	AddNode(nodeName string, subnets []*net.IPNet, policies []*ipallocator.Policy) error
+	// UpdatePolicies rewrites the allocation Policy recorded for each of
+	// nodeName's existing subnets (policies[i] for subnets[i], in the
+	// order they were registered with AddNode), without touching the
+	// subnets themselves or any recorded owner. It's used to persist an
+	// AddNodeWithPolicy reconcile against an already-registered switch, so
+	// a restart doesn't rehydrate allocators against a stale policy. A nil
+	// policies slice clears every subnet's policy.
+	UpdatePolicies(nodeName string, policies []*ipallocator.Policy) error
+	// ReserveOwner records, in a single transaction, that ownerKey holds
+	// ips on nodeName's switch.
+	ReserveOwner(nodeName, ownerKey string, ips []*net.IPNet) error
+	// ReleaseOwner forgets, in a single transaction, the ips held by
+	// ownerKey on nodeName's switch.
+	ReleaseOwner(nodeName, ownerKey string, ips []*net.IPNet) error
+	// Nodes returns every node the store knows about, keyed by node name,
+	// so that newLogicalSwitchManagerWithStore can rehydrate its
+	// in-memory allocators without talking to OVN.
+	Nodes() (map[string]storedNode, error)
+	// Close releases the underlying database handle.
+	Close() error
+}
+
+// boltIPAMStore is the default IPAMStore, backed by a boltdb file. Each node
+// gets its own top-level bucket containing an "assignments" bucket plus one
+// bucket per subnet CIDR mapping allocated IPs to their owner key.
+type boltIPAMStore struct {
+	db *bolt.DB
+}
+
+// NewBoltIPAMStore opens (creating if necessary) a boltdb-backed IPAMStore
+// at path.
+func NewBoltIPAMStore(path string) (IPAMStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IPAM store at %q: %v", path, err)
+	}
+	return &boltIPAMStore{db: db}, nil
+}
+
+func (s *boltIPAMStore) AddNode(nodeName string, subnets []*net.IPNet, policies []*ipallocator.Policy) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(nodeName)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		nodeBucket, err := tx.CreateBucket([]byte(nodeName))
+		if err != nil {
+			return err
+		}
+		if _, err := nodeBucket.CreateBucket([]byte(assignmentsBucket)); err != nil {
+			return err
+		}
+		entries := make([]subnetEntry, len(subnets))
+		for i, subnet := range subnets {
+			if _, err := nodeBucket.CreateBucket([]byte(subnet.String())); err != nil {
+				return err
+			}
+			entries[i].Subnet = subnet
+			if policies != nil {
+				entries[i].Policy = policies[i]
+			}
+		}
+		payload, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		return nodeBucket.Put([]byte(subnetOrderKey), payload)
+	})
+}
+
+func (s *boltIPAMStore) UpdatePolicies(nodeName string, policies []*ipallocator.Policy) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		nodeBucket := tx.Bucket([]byte(nodeName))
+		if nodeBucket == nil {
+			return fmt.Errorf("no IPAM store bucket for node %q", nodeName)
+		}
+		var entries []subnetEntry
+		if payload := nodeBucket.Get([]byte(subnetOrderKey)); payload != nil {
+			if err := json.Unmarshal(payload, &entries); err != nil {
+				return err
+			}
+		}
+		if policies != nil && len(policies) != len(entries) {
+			return fmt.Errorf("expected %d policies for switch %q, got %d", len(entries), nodeName, len(policies))
+		}
+		for i := range entries {
+			if policies != nil {
+				entries[i].Policy = policies[i]
+			} else {
+				entries[i].Policy = nil
+			}
+		}
+		payload, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		return nodeBucket.Put([]byte(subnetOrderKey), payload)
+	})
+}
+
+func (s *boltIPAMStore) ReserveOwner(nodeName, ownerKey string, ips []*net.IPNet) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		nodeBucket := tx.Bucket([]byte(nodeName))
+		if nodeBucket == nil {
+			return fmt.Errorf("no IPAM store bucket for node %q", nodeName)
+		}
+		payload, err := json.Marshal(ips)
+		if err != nil {
+			return err
+		}
+		if err := nodeBucket.Bucket([]byte(assignmentsBucket)).Put([]byte(ownerKey), payload); err != nil {
+			return err
+		}
+		for _, ipnet := range ips {
+			subnetBucket, err := findSubnetBucket(nodeBucket, ipnet.IP)
+			if err != nil {
+				return err
+			}
+			if err := subnetBucket.Put([]byte(ipnet.IP.String()), []byte(ownerKey)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltIPAMStore) ReleaseOwner(nodeName, ownerKey string, ips []*net.IPNet) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		nodeBucket := tx.Bucket([]byte(nodeName))
+		if nodeBucket == nil {
+			return fmt.Errorf("no IPAM store bucket for node %q", nodeName)
+		}
+		if err := nodeBucket.Bucket([]byte(assignmentsBucket)).Delete([]byte(ownerKey)); err != nil {
+			return err
+		}
+		for _, ipnet := range ips {
+			subnetBucket, err := findSubnetBucket(nodeBucket, ipnet.IP)
+			if err != nil {
+				continue
+			}
+			if err := subnetBucket.Delete([]byte(ipnet.IP.String())); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltIPAMStore) Nodes() (map[string]storedNode, error) {
+	nodes := map[string]storedNode{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, nodeBucket *bolt.Bucket) error {
+			stored := storedNode{}
+			if payload := nodeBucket.Get([]byte(subnetOrderKey)); payload != nil {
+				var entries []subnetEntry
+				if err := json.Unmarshal(payload, &entries); err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					stored.Subnets = append(stored.Subnets, entry.Subnet)
+					stored.Policies = append(stored.Policies, entry.Policy)
+				}
+			}
+			err := nodeBucket.Bucket([]byte(assignmentsBucket)).ForEach(func(ownerKey, payload []byte) error {
+				var ips []*net.IPNet
+				if err := json.Unmarshal(payload, &ips); err != nil {
+					return err
+				}
+				stored.Owners = append(stored.Owners, ownerAllocation{OwnerKey: string(ownerKey), IPs: ips})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			nodes[string(name)] = stored
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func (s *boltIPAMStore) Close() error {
+	return s.db.Close()
+}
+
+// findSubnetBucket returns the subnet bucket under nodeBucket whose CIDR
+// contains ip.
+func findSubnetBucket(nodeBucket *bolt.Bucket, ip net.IP) (*bolt.Bucket, error) {
+	var found *bolt.Bucket
+	c := nodeBucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil || string(k) == assignmentsBucket {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(string(k))
+		if err != nil {
+			continue
+		}
+		if subnet.Contains(ip) {
+			found = nodeBucket.Bucket(k)
+			break
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no subnet bucket covers IP %s", ip)
+	}
+	return found, nil
+}
+
+// noopIPAMStore is an in-memory IPAMStore that persists nothing. It backs
+// newLogicalSwitchManager for callers (and tests) that don't need
+// allocations to survive a restart.
+type noopIPAMStore struct{}
+
+func newNoopIPAMStore() IPAMStore {
+	return noopIPAMStore{}
+}
+
+func (noopIPAMStore) AddNode(nodeName string, subnets []*net.IPNet, policies []*ipallocator.Policy) error {
+	return nil
+}
+func (noopIPAMStore) UpdatePolicies(nodeName string, policies []*ipallocator.Policy) error { return nil }
+func (noopIPAMStore) ReserveOwner(nodeName, ownerKey string, ips []*net.IPNet) error        { return nil }
+func (noopIPAMStore) ReleaseOwner(nodeName, ownerKey string, ips []*net.IPNet) error        { return nil }
+func (noopIPAMStore) Nodes() (map[string]storedNode, error)                                { return nil, nil }
+func (noopIPAMStore) Close() error                                                         { return nil }
@@ -0,0 +1,153 @@
+package ovn
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	ipallocator "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/allocator/ip"
+	ovntest "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OVN IPAM persistent store", func() {
+	var dbDir string
+
+	BeforeEach(func() {
+		var err error
+		dbDir, err = ioutil.TempDir("", "ipam-store")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dbDir)
+	})
+
+	It("survives allocations across a fresh manager pointed at the same DB path", func() {
+		dbPath := filepath.Join(dbDir, "ipam.db")
+		store, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		lsManager := newLogicalSwitchManagerWithStore(store)
+
+		nodeName := "testNode1"
+		subnets := ovntest.MustParseIPNets("10.1.1.0/24", "2000::/64")
+		Expect(lsManager.AddNode(nodeName, subnets)).To(Succeed())
+
+		ips, err := lsManager.AllocateNextIPs(nodeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips[0].IP.String()).To(Equal("10.1.1.3"))
+		Expect(ips[1].IP.String()).To(Equal("2000::3"))
+
+		Expect(store.Close()).To(Succeed())
+
+		// a brand new manager pointed at the same DB path should rehydrate
+		// the allocation above without talking to OVN
+		reopened, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer reopened.Close()
+		freshManager := newLogicalSwitchManagerWithStore(reopened)
+
+		nextIPs, err := freshManager.AllocateNextIPs(nodeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nextIPs[0].IP.String()).To(Equal("10.1.1.4"))
+		Expect(nextIPs[1].IP.String()).To(Equal("2000::4"))
+	})
+
+	It("records no on-disk assignment when an in-memory allocation fails", func() {
+		dbPath := filepath.Join(dbDir, "ipam.db")
+		store, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer store.Close()
+		lsManager := newLogicalSwitchManagerWithStore(store)
+
+		nodeName := "testNode1"
+		subnets := ovntest.MustParseIPNets("10.1.1.0/29")
+		Expect(lsManager.AddNode(nodeName, subnets)).To(Succeed())
+
+		// exhaust the only four allocatable addresses (.3 through .6)
+		for i := 0; i < 4; i++ {
+			_, err := lsManager.AllocateNextIPs(nodeName)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		_, err = lsManager.AllocateNextIPs(nodeName)
+		Expect(err).To(HaveOccurred())
+
+		nodes, err := store.Nodes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodes[nodeName].Owners).To(HaveLen(4))
+	})
+
+	It("reapplies an AddNodeWithPolicy range/exclusion policy across a restart", func() {
+		dbPath := filepath.Join(dbDir, "ipam.db")
+		store, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		lsManager := newLogicalSwitchManagerWithStore(store)
+
+		nodeName := "testNode1"
+		subnets := ovntest.MustParseIPNets("10.1.1.0/28")
+		policies := []*ipallocator.Policy{{
+			RangeStart: net.ParseIP("10.1.1.5"),
+			RangeEnd:   net.ParseIP("10.1.1.10"),
+			Excludes:   ovntest.MustParseIPNets("10.1.1.7/32"),
+		}}
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, policies)).To(Succeed())
+		Expect(store.Close()).To(Succeed())
+
+		reopened, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer reopened.Close()
+		freshManager := newLogicalSwitchManagerWithStore(reopened)
+
+		// the excluded address must still be excluded, and the range
+		// floor/ceiling still enforced, after rehydrating from disk
+		Expect(freshManager.AllocateIPs(nodeName, ovntest.MustParseIPNets("10.1.1.7/28"))).NotTo(Succeed())
+		Expect(freshManager.AllocateIPs(nodeName, ovntest.MustParseIPNets("10.1.1.3/28"))).NotTo(Succeed())
+
+		ips, err := freshManager.AllocateNextIPs(nodeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips[0].IP.String()).To(Equal("10.1.1.5"))
+	})
+
+	It("does not hand out a duplicate IP after a policy is widened, the store restarts, and the widened policy is reapplied", func() {
+		dbPath := filepath.Join(dbDir, "ipam.db")
+		store, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		lsManager := newLogicalSwitchManagerWithStore(store)
+
+		nodeName := "testNode1"
+		subnets := ovntest.MustParseIPNets("10.1.1.0/28")
+		narrow := []*ipallocator.Policy{{
+			RangeStart: net.ParseIP("10.1.1.5"),
+			RangeEnd:   net.ParseIP("10.1.1.8"),
+		}}
+		wide := []*ipallocator.Policy{{
+			RangeStart: net.ParseIP("10.1.1.5"),
+			RangeEnd:   net.ParseIP("10.1.1.14"),
+		}}
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, narrow)).To(Succeed())
+
+		// reconcile onto the existing switch with a widened range; this
+		// must be persisted, not just applied in memory
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, wide)).To(Succeed())
+
+		// only reachable under the widened range
+		Expect(lsManager.AllocateIPs(nodeName, ovntest.MustParseIPNets("10.1.1.11/28"))).To(Succeed())
+
+		Expect(store.Close()).To(Succeed())
+
+		reopened, err := NewBoltIPAMStore(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer reopened.Close()
+		freshManager := newLogicalSwitchManagerWithStore(reopened)
+
+		// a resync re-applying the same widened policy must not forget
+		// that 10.1.1.11 is already held by the original owner
+		Expect(freshManager.AddNodeWithPolicy(nodeName, subnets, wide)).To(Succeed())
+		err = freshManager.AllocateIPs(nodeName, ovntest.MustParseIPNets("10.1.1.11/28"))
+		Expect(err).To(HaveOccurred())
+	})
+})
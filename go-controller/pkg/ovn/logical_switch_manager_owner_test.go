@@ -0,0 +1,61 @@
+package ovn
+
+import (
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/config"
+	ovntest "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OVN Logical Switch Manager owner-keyed allocation", func() {
+	var lsManager *logicalSwitchManager
+
+	BeforeEach(func() {
+		config.PrepareTestConfig()
+		lsManager = newLogicalSwitchManager()
+	})
+
+	It("returns the same IPs for repeated AllocateNextIPsFor calls with the same owner key", func() {
+		nodeName := "testNode1"
+		ownerKey := "pod-uid-1/eth0"
+		subnets := ovntest.MustParseIPNets("10.1.1.0/24", "2000::/64")
+		Expect(lsManager.AddNode(nodeName, subnets)).To(Succeed())
+
+		first, err := lsManager.AllocateNextIPsFor(nodeName, ownerKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first[0].IP.String()).To(Equal("10.1.1.3"))
+
+		second, err := lsManager.AllocateNextIPsFor(nodeName, ownerKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(Equal(first))
+
+		// a different owner on the same switch gets the next free address,
+		// proving the retry above didn't consume one
+		other, err := lsManager.AllocateNextIPsFor(nodeName, "pod-uid-2/eth0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other[0].IP.String()).To(Equal("10.1.1.4"))
+	})
+
+	It("frees the owner's IPs on ReleaseIPsFor and allows reallocation", func() {
+		nodeName := "testNode1"
+		ownerKey := "pod-uid-1/eth0"
+		subnets := ovntest.MustParseIPNets("10.1.1.0/24")
+		Expect(lsManager.AddNode(nodeName, subnets)).To(Succeed())
+
+		ips, err := lsManager.AllocateNextIPsFor(nodeName, ownerKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips[0].IP.String()).To(Equal("10.1.1.3"))
+
+		Expect(lsManager.ReleaseIPsFor(ownerKey)).To(Succeed())
+
+		// releasing an owner with no allocation is a no-op, not an error
+		Expect(lsManager.ReleaseIPsFor(ownerKey)).To(Succeed())
+
+		// the released owner key allocates afresh; the freed address isn't
+		// revisited until the bitmap wraps back around, so it gets the next one
+		reallocated, err := lsManager.AllocateNextIPsFor(nodeName, ownerKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reallocated[0].IP.String()).To(Equal("10.1.1.4"))
+	})
+})
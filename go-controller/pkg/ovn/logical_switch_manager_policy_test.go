@@ -0,0 +1,72 @@
+package ovn
+
+import (
+	"net"
+
+	ipallocator "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/allocator/ip"
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/config"
+	ovntest "github.com/ovn-org/ovn-kubernetes/go-controller/pkg/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OVN Logical Switch Manager allocation policy", func() {
+	var lsManager *logicalSwitchManager
+	nodeName := "testNode1"
+	subnets := ovntest.MustParseIPNets("10.1.1.0/28")
+
+	policy := func(rangeEnd string) []*ipallocator.Policy {
+		return []*ipallocator.Policy{{
+			RangeStart: net.ParseIP("10.1.1.5"),
+			RangeEnd:   net.ParseIP(rangeEnd),
+			Excludes:   ovntest.MustParseIPNets("10.1.1.7/32"),
+		}}
+	}
+
+	BeforeEach(func() {
+		config.PrepareTestConfig()
+		lsManager = newLogicalSwitchManager()
+	})
+
+	It("never hands out an excluded IP and exhausts at RangeEnd, not the subnet broadcast address", func() {
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, policy("10.1.1.10"))).To(Succeed())
+
+		var got []string
+		for i := 0; i < 5; i++ {
+			ips, err := lsManager.AllocateNextIPs(nodeName)
+			Expect(err).NotTo(HaveOccurred())
+			got = append(got, ips[0].IP.String())
+		}
+		Expect(got).To(Equal([]string{"10.1.1.5", "10.1.1.6", "10.1.1.8", "10.1.1.9", "10.1.1.10"}))
+		Expect(got).NotTo(ContainElement("10.1.1.7"))
+
+		_, err := lsManager.AllocateNextIPs(nodeName)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to allocate an excluded IP explicitly", func() {
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, policy("10.1.1.10"))).To(Succeed())
+
+		err := lsManager.AllocateIPs(nodeName, ovntest.MustParseIPNets("10.1.1.7/28"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reconciles a widened policy at AddNode time without dropping existing allocations", func() {
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, policy("10.1.1.10"))).To(Succeed())
+
+		ips, err := lsManager.AllocateNextIPs(nodeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ips[0].IP.String()).To(Equal("10.1.1.5"))
+
+		// widen the range; the pre-existing allocation must still be held
+		Expect(lsManager.AddNodeWithPolicy(nodeName, subnets, policy("10.1.1.12"))).To(Succeed())
+
+		err = lsManager.AllocateIPs(nodeName, ovntest.MustParseIPNets("10.1.1.5/28"))
+		Expect(err).To(HaveOccurred())
+
+		next, err := lsManager.AllocateNextIPs(nodeName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next[0].IP.String()).To(Equal("10.1.1.6"))
+	})
+})